@@ -0,0 +1,133 @@
+// Copyright 2020 ConsenSys Software Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bavard
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// Job describes one output of a GenerateBatch call: Templates and Data are executed exactly
+// as they would be through Generate, and the result is written to Output.
+type Job struct {
+	Output    string
+	Templates []string
+	Data      interface{}
+}
+
+// BatchResult reports, for one Job, the hex-encoded SHA-256 of the rendered and formatted
+// output. SHA256 is only populated when the batch was run with DryRun(true).
+type BatchResult struct {
+	Output string
+	SHA256 string
+}
+
+// GenerateBatch runs Generate for each Job, distributing the work across a pool of
+// runtime.NumCPU() workers. Options are shared by every Job in the batch. If DryRun(true) is
+// among options, no file is written to disk: the returned results carry the SHA-256 of what
+// would have been generated instead, sorted by Output, so callers (CI, mostly) can detect
+// stale generated code without touching the working tree.
+func GenerateBatch(jobs []Job, options ...func(*Bavard) error) ([]BatchResult, error) {
+	var b Bavard
+
+	// default settings, mirroring Generate
+	b.imports = true
+	b.fmt = true
+	b.verbose = true
+	b.generated = "bavard"
+
+	for _, option := range options {
+		if err := option(&b); err != nil {
+			return nil, err
+		}
+	}
+
+	type indexedResult struct {
+		index int
+		res   BatchResult
+		err   error
+	}
+
+	workers := runtime.NumCPU()
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobCh := make(chan int)
+	resultCh := make(chan indexedResult, len(jobs))
+
+	// runJob renders a single Job, recovering from a panic (template.Must on a malformed
+	// template is one source) so that one bad Job surfaces as an error on its own result
+	// instead of taking down the whole batch.
+	runJob := func(idx int) (res indexedResult) {
+		job := jobs[idx]
+		res.index = idx
+		res.res.Output = job.Output
+		defer func() {
+			if r := recover(); r != nil {
+				res.err = fmt.Errorf("bavard: generating %s: %v", job.Output, r)
+			}
+		}()
+		jobOptions := b
+		digest, err := generate(&jobOptions, job.Output, aggregate(job.Templates), nil, job.Data)
+		res.res.SHA256 = digest
+		res.err = err
+		return res
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for idx := range jobCh {
+				resultCh <- runJob(idx)
+			}
+		}()
+	}
+
+	go func() {
+		for i := range jobs {
+			jobCh <- i
+		}
+		close(jobCh)
+	}()
+
+	go func() {
+		wg.Wait()
+		close(resultCh)
+	}()
+
+	results := make([]BatchResult, len(jobs))
+	var firstErr error
+	for r := range resultCh {
+		if r.err != nil && firstErr == nil {
+			firstErr = r.err
+			continue
+		}
+		results[r.index] = r.res
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Output < results[j].Output })
+	return results, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 ConsenSys Software Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bavard
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateBatchDryRunIsStableAndSorted(t *testing.T) {
+	jobs := []Job{
+		{Output: "b.txt", Templates: []string{"B: {{.}}"}, Data: "hello"},
+		{Output: "a.txt", Templates: []string{"A: {{.}}"}, Data: "hello"},
+	}
+
+	first, err := GenerateBatch(jobs, DryRun(true), Verbose(false))
+	if err != nil {
+		t.Fatalf("GenerateBatch: %v", err)
+	}
+	second, err := GenerateBatch(jobs, DryRun(true), Verbose(false))
+	if err != nil {
+		t.Fatalf("GenerateBatch: %v", err)
+	}
+
+	if len(first) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(first))
+	}
+	if first[0].Output != "a.txt" || first[1].Output != "b.txt" {
+		t.Fatalf("results not sorted by Output: %+v", first)
+	}
+	for i := range first {
+		if first[i].SHA256 == "" {
+			t.Errorf("result %d: expected a non-empty SHA256", i)
+		}
+		if first[i].SHA256 != second[i].SHA256 {
+			t.Errorf("hash not stable across runs: %q != %q", first[i].SHA256, second[i].SHA256)
+		}
+	}
+	if first[0].SHA256 == first[1].SHA256 {
+		t.Errorf("distinct jobs hashed to the same digest: %q", first[0].SHA256)
+	}
+
+	// DryRun must never touch disk.
+	if _, err := os.Stat("a.txt"); err == nil {
+		t.Error("DryRun(true) should not have written a.txt")
+		os.Remove("a.txt")
+	}
+}
+
+func TestGenerateBatchRecoversPanickingJob(t *testing.T) {
+	dir := t.TempDir()
+	jobs := []Job{
+		{Output: filepath.Join(dir, "good.txt"), Templates: []string{"ok: {{.}}"}, Data: "x"},
+		// missing {{end}}: template.Must panics on Parse.
+		{Output: filepath.Join(dir, "bad.txt"), Templates: []string{"{{if .}}"}, Data: "x"},
+	}
+
+	if _, err := GenerateBatch(jobs, Verbose(false), Import(false)); err == nil {
+		t.Fatal("expected an error from the malformed template, got nil")
+	}
+
+	if _, statErr := os.Stat(filepath.Join(dir, "good.txt")); statErr != nil {
+		t.Errorf("the well-formed job should still have been rendered: %v", statErr)
+	}
+}
+
+func TestGenerateBatchReportsExecutionError(t *testing.T) {
+	dir := t.TempDir()
+	jobs := []Job{
+		{Output: filepath.Join(dir, "out.txt"), Templates: []string{"{{.NoSuchField}}"}, Data: struct{}{}},
+	}
+
+	if _, err := GenerateBatch(jobs, Verbose(false), Import(false)); err == nil {
+		t.Fatal("expected an error executing a template referencing a missing field")
+	}
+}
@@ -17,8 +17,9 @@
 package bavard
 
 import (
-	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -30,15 +31,20 @@ import (
 
 // Bavard root object to configure the code generation from text/template
 type Bavard struct {
-	verbose     bool
-	fmt         bool
-	imports     bool
-	packageName string
-	packageDoc  string
-	license     string
-	generated   string
-	buildTag    string
-	funcs       template.FuncMap
+	verbose         bool
+	fmt             bool
+	imports         bool
+	packageName     string
+	packageDoc      string
+	license         string
+	generated       string
+	buildTag        string
+	funcs           template.FuncMap
+	formatters      map[string]Formatter
+	dryRun          bool
+	cacheDir        string
+	skipUnchanged   bool
+	jsonDiagnostics bool
 }
 
 // Generate will concatenate templates and create output file from executing the resulting text/template
@@ -59,13 +65,75 @@ func Generate(output string, templates []string, data interface{}, options ...fu
 		}
 	}
 
-	// create output dir if not exist
-	_ = os.MkdirAll(filepath.Dir(output), os.ModePerm)
+	// templates are plain-concatenated, as they always have been: callers are free to split a
+	// single template across entries (e.g. open an {{if}} in one fragment and {{end}} it in
+	// another), so they cannot be parsed as independently named, self-contained templates the
+	// way GenerateFS's files can. No SourceMap is available here as a result; template errors
+	// are reported without a source file.
+	_, err := generate(&b, output, aggregate(templates), nil, data)
+	return err
+}
+
+// aggregate concatenates templates in order, exactly as they will be parsed and executed.
+func aggregate(templates []string) string {
+	var sb strings.Builder
+	for _, t := range templates {
+		sb.WriteString(t)
+	}
+	return sb.String()
+}
+
+// generate renders templateText into output, applying the settings already gathered on b. sm
+// lets template execution errors be reported against their original source (see TemplateError).
+// If b.dryRun is set, output is never written to: the result is rendered to a temporary
+// file, formatted exactly as it would be on disk, hashed, then discarded. In dry-run mode it
+// returns the hex-encoded SHA-256 of the final, formatted bytes; otherwise digest is empty.
+func generate(b *Bavard, output string, templateText string, sm SourceMap, data interface{}) (digest string, err error) {
+	var key string
+	if b.cacheDir != "" {
+		key, err = cacheKey(templateText, data)
+		if err != nil {
+			return "", err
+		}
+		cached, rerr := ioutil.ReadFile(filepath.Join(b.cacheDir, key))
+		if rerr == nil {
+			if b.dryRun {
+				sum := sha256.Sum256(cached)
+				return hex.EncodeToString(sum[:]), nil
+			}
+			return "", writeCachedOutput(b, output, cached)
+		} else if !os.IsNotExist(rerr) {
+			return "", rerr
+		}
+	}
+
+	var preStat os.FileInfo
+	var oldBytes []byte
+	if b.skipUnchanged && !b.dryRun {
+		if info, serr := os.Stat(output); serr == nil {
+			preStat = info
+			oldBytes, _ = ioutil.ReadFile(output)
+		}
+	}
+
+	target := output
+	if b.dryRun {
+		tmp, err := ioutil.TempFile("", "bavard-dryrun-*"+filepath.Ext(output))
+		if err != nil {
+			return "", err
+		}
+		target = tmp.Name()
+		tmp.Close()
+		defer os.Remove(target)
+	} else {
+		// create output dir if not exist
+		_ = os.MkdirAll(filepath.Dir(output), os.ModePerm)
+	}
 
 	// create output file
-	file, err := os.Create(output)
+	file, err := os.Create(target)
 	if err != nil {
-		return err
+		return "", err
 	}
 	if b.verbose {
 		fmt.Printf("generating %-70s\n", output)
@@ -73,30 +141,30 @@ func Generate(output string, templates []string, data interface{}, options ...fu
 
 	if b.buildTag != "" {
 		if _, err := file.WriteString("// +build " + b.buildTag + "\n"); err != nil {
-			return err
+			return "", err
 		}
 	}
 
 	if b.license != "" {
 		if _, err := file.WriteString(b.license + "\n"); err != nil {
-			return err
+			return "", err
 		}
 	}
 	if _, err := file.WriteString(fmt.Sprintf("// Code generated by %s DO NOT EDIT\n\n", b.generated)); err != nil {
-		return err
+		return "", err
 	}
 
 	if b.packageName != "" {
 		if b.packageDoc != "" {
 			if _, err := file.WriteString("// Package " + b.packageName + " "); err != nil {
-				return err
+				return "", err
 			}
 			if _, err := file.WriteString(b.packageDoc + "\n"); err != nil {
-				return err
+				return "", err
 			}
 		}
 		if _, err := file.WriteString("package " + b.packageName + "\n\n"); err != nil {
-			return err
+			return "", err
 		}
 	}
 
@@ -105,96 +173,94 @@ func Generate(output string, templates []string, data interface{}, options ...fu
 	for k, v := range b.funcs {
 		fnHelpers[k] = v
 	}
+	// Include looks up a template previously registered by name (any {{define "name"}} block,
+	// including the ones GenerateFS registers per source file) and renders it to a string.
+	// Template functions have no implicit ".", so the data to render it with must be passed
+	// explicitly: {{ Include "name" . }} or {{ Include "name" .Field }}.
+	var tmplRoot *template.Template
+	fnHelpers["Include"] = func(name string, data interface{}) (string, error) {
+		t := tmplRoot.Lookup(name)
+		if t == nil {
+			return "", fmt.Errorf("bavard: Include: no such template %q", name)
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
 	tmpl := template.Must(template.New("").
 		Funcs(fnHelpers).
-		Parse(aggregate(templates)))
+		Parse(templateText))
+	tmplRoot = tmpl
 
 	// execute template
 	if err = tmpl.Execute(file, data); err != nil {
 		file.Close()
-		return err
+		wrapped := wrapExecError(err, templateText, sm)
+		if b.jsonDiagnostics {
+			if te, ok := wrapped.(*TemplateError); ok {
+				emitDiagnostic(te)
+			}
+		}
+		return "", wrapped
 	}
 	file.Close()
 
 	// format generated code
 	if b.fmt {
-		switch filepath.Ext(output) {
-		case ".go":
-			cmd := exec.Command("gofmt", "-s", "-w", output)
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			if err := cmd.Run(); err != nil {
-				return err
-			}
-		case ".s":
-			// a quick and dirty formatter, not even in place
-
-			// 1- create result buffer
-			var result bytes.Buffer
-
-			// 2- open file
-			file, err := os.Open(output)
-			if err != nil {
-				return err
-			}
-
-			scanner := bufio.NewScanner(file)
-			prevLine := false
-			for scanner.Scan() {
-				line := strings.TrimSpace(scanner.Text())
-				isJump := line == ""
-				if (isJump && !prevLine) || !isJump {
-					result.WriteString(line)
-					result.WriteByte('\n')
-				}
-				if strings.HasPrefix(line, "TEXT ") {
-					break
-				}
-				prevLine = isJump
-			}
-			prevLine = false
-			for scanner.Scan() {
-				line := strings.TrimSpace(scanner.Text())
-				isJump := line == ""
-				if (isJump && !prevLine) || !isJump {
-					result.WriteString("    " + line)
-					result.WriteByte('\n')
-				}
-				prevLine = isJump
-			}
-
-			if err := scanner.Err(); err != nil {
-				file.Close()
-				return err
-			}
-			file.Close()
-
-			err = ioutil.WriteFile(output, result.Bytes(), 0644)
-			if err != nil {
-				return err
+		if f := b.formatterFor(filepath.Ext(output)); f != nil {
+			if err := f.Format(target); err != nil {
+				return "", err
 			}
 		}
-
 	}
 
-	// run goimports on generated code
-	if b.imports {
-		cmd := exec.Command("goimports", "-w", output)
+	// run goimports on generated code; goimports only understands Go source, so skip it for
+	// the non-Go targets a Formatter may have been registered for
+	if b.imports && filepath.Ext(output) == ".go" {
+		cmd := exec.Command("goimports", "-w", target)
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 		if err := cmd.Run(); err != nil {
-			return err
+			return "", err
+		}
+	}
+
+	if b.dryRun {
+		rendered, err := ioutil.ReadFile(target)
+		if err != nil {
+			return "", err
 		}
+		sum := sha256.Sum256(rendered)
+		digest = hex.EncodeToString(sum[:])
+		return digest, nil
 	}
-	return nil
+
+	final, err := ioutil.ReadFile(target)
+	if err != nil {
+		return "", err
+	}
+
+	if b.skipUnchanged && oldBytes != nil && bytes.Equal(stripGeneratedLine(final), stripGeneratedLine(oldBytes)) {
+		_ = os.Chtimes(target, preStat.ModTime(), preStat.ModTime())
+	}
+
+	if b.cacheDir != "" {
+		_ = os.MkdirAll(b.cacheDir, os.ModePerm)
+		_ = ioutil.WriteFile(filepath.Join(b.cacheDir, key), final, 0644)
+	}
+
+	return "", nil
 }
 
-func aggregate(values []string) string {
-	var sb strings.Builder
-	for _, v := range values {
-		sb.WriteString(v)
+// formatterFor returns the Formatter registered for ext, preferring one scoped to this
+// Bavard instance (via WithFormatter) over the package-wide registry (via Register)
+func (b *Bavard) formatterFor(ext string) Formatter {
+	if f, ok := b.formatters[ext]; ok {
+		return f
 	}
-	return sb.String()
+	return formatters[ext]
 }
 
 // Apache2Header returns a Apache2 header string
@@ -225,6 +291,132 @@ func Apache2(copyrightHolder string, year int) func(*Bavard) error {
 	}
 }
 
+// MITHeader returns a MIT header string
+func MITHeader(copyrightHolder string, year int) string {
+	mit := `
+	// Copyright %d %s
+	//
+	// Permission is hereby granted, free of charge, to any person obtaining a copy
+	// of this software and associated documentation files (the "Software"), to deal
+	// in the Software without restriction, including without limitation the rights
+	// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+	// copies of the Software, and to permit persons to whom the Software is
+	// furnished to do so, subject to the following conditions:
+	//
+	// The above copyright notice and this permission notice shall be included in all
+	// copies or substantial portions of the Software.
+	//
+	// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+	// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+	// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+	// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+	// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+	// SOFTWARE.
+	`
+	return fmt.Sprintf(mit, year, copyrightHolder)
+}
+
+// MIT returns a bavard option to be used in Generate writing a MIT licence header in the generated file
+func MIT(copyrightHolder string, year int) func(*Bavard) error {
+	return func(b *Bavard) error {
+		b.license = MITHeader(copyrightHolder, year)
+		return nil
+	}
+}
+
+// BSD3Header returns a BSD 3-Clause header string
+func BSD3Header(copyrightHolder string, year int) string {
+	bsd3 := `
+	// Copyright %d %s
+	//
+	// Redistribution and use in source and binary forms, with or without
+	// modification, are permitted provided that the following conditions are met:
+	//
+	// 1. Redistributions of source code must retain the above copyright notice, this
+	//    list of conditions and the following disclaimer.
+	//
+	// 2. Redistributions in binary form must reproduce the above copyright notice,
+	//    this list of conditions and the following disclaimer in the documentation
+	//    and/or other materials provided with the distribution.
+	//
+	// 3. Neither the name of the copyright holder nor the names of its
+	//    contributors may be used to endorse or promote products derived from
+	//    this software without specific prior written permission.
+	//
+	// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+	// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+	// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+	// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+	// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+	// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+	// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+	// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+	// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+	// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+	`
+	return fmt.Sprintf(bsd3, year, copyrightHolder)
+}
+
+// BSD3 returns a bavard option to be used in Generate writing a BSD 3-Clause licence header in the generated file
+func BSD3(copyrightHolder string, year int) func(*Bavard) error {
+	return func(b *Bavard) error {
+		b.license = BSD3Header(copyrightHolder, year)
+		return nil
+	}
+}
+
+// GPL3Header returns a GPLv3 header string
+func GPL3Header(copyrightHolder string, year int) string {
+	gpl3 := `
+	// Copyright %d %s
+	//
+	// This program is free software: you can redistribute it and/or modify
+	// it under the terms of the GNU General Public License as published by
+	// the Free Software Foundation, either version 3 of the License, or
+	// (at your option) any later version.
+	//
+	// This program is distributed in the hope that it will be useful,
+	// but WITHOUT ANY WARRANTY; without even the implied warranty of
+	// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+	// GNU General Public License for more details.
+	//
+	// You should have received a copy of the GNU General Public License
+	// along with this program. If not, see <https://www.gnu.org/licenses/>.
+	`
+	return fmt.Sprintf(gpl3, year, copyrightHolder)
+}
+
+// GPL3 returns a bavard option to be used in Generate writing a GPLv3 licence header in the generated file
+func GPL3(copyrightHolder string, year int) func(*Bavard) error {
+	return func(b *Bavard) error {
+		b.license = GPL3Header(copyrightHolder, year)
+		return nil
+	}
+}
+
+// SPDXHeader returns a single line SPDX license identifier header, e.g. "// SPDX-License-Identifier: MIT"
+func SPDXHeader(id string) string {
+	return fmt.Sprintf("// SPDX-License-Identifier: %s", id)
+}
+
+// SPDX returns a bavard option to be used in Generate writing a SPDX-License-Identifier line in the generated file
+func SPDX(id string) func(*Bavard) error {
+	return func(b *Bavard) error {
+		b.license = SPDXHeader(id)
+		return nil
+	}
+}
+
+// CustomLicense returns a bavard option to be used in Generate writing the given pre-rendered text
+// as the license header in the generated file, without any additional templating
+func CustomLicense(text string) func(*Bavard) error {
+	return func(b *Bavard) error {
+		b.license = text
+		return nil
+	}
+}
+
 // GeneratedBy returns a bavard option to be used in Generate writing a standard
 // "Code generated by 'label' DO NOT EDIT"
 func GeneratedBy(label string) func(*Bavard) error {
@@ -285,3 +477,13 @@ func Funcs(funcs template.FuncMap) func(*Bavard) error {
 		return nil
 	}
 }
+
+// DryRun returns a bavard option to be used in Generate or GenerateBatch. If set to true, the
+// output file is never written: the template is still rendered and formatted exactly as it
+// would be on disk, but the result is discarded after being hashed. See GenerateBatch.
+func DryRun(v bool) func(*Bavard) error {
+	return func(b *Bavard) error {
+		b.dryRun = v
+		return nil
+	}
+}
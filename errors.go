@@ -0,0 +1,172 @@
+// Copyright 2020 ConsenSys Software Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bavard
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// sourceMapEntry records the line, in the aggregated template text, at which a NamedTemplate's
+// content begins.
+type sourceMapEntry struct {
+	Name      string
+	StartLine int
+}
+
+// SourceMap maps a line number in text produced by AggregateWithSourceMap back to the
+// NamedTemplate it came from, so template execution errors can be reported against the original
+// source file instead of the aggregated offset.
+type SourceMap []sourceMapEntry
+
+// locate returns the Name and line, relative to that Name's content, that aggregatedLine falls
+// into. If aggregatedLine is before the first entry, it returns ("", aggregatedLine).
+func (sm SourceMap) locate(aggregatedLine int) (name string, line int) {
+	line = aggregatedLine
+	for _, e := range sm {
+		if aggregatedLine >= e.StartLine {
+			name, line = e.Name, aggregatedLine-e.StartLine+1
+		}
+	}
+	return name, line
+}
+
+// AggregateWithSourceMap wraps each NamedTemplate in a {{define}} block keyed by its Name and
+// appends a {{template}} call for each, in order, reproducing the straight-concatenation
+// behavior Generate and GenerateFS rely on while keeping every file addressable by name (see
+// Include) and traceable back to its source (see TemplateError).
+func AggregateWithSourceMap(templates []NamedTemplate) (string, SourceMap) {
+	var sb strings.Builder
+	sm := make(SourceMap, 0, len(templates))
+	line := 1
+	for _, t := range templates {
+		header := fmt.Sprintf("{{define %q}}", t.Name)
+		sb.WriteString(header)
+		line += strings.Count(header, "\n")
+
+		sm = append(sm, sourceMapEntry{Name: t.Name, StartLine: line})
+
+		sb.WriteString(t.Content)
+		line += strings.Count(t.Content, "\n")
+
+		// the newline separating blocks sits inside a template comment, so it keeps line numbers
+		// apart for the SourceMap without being emitted into the output
+		sb.WriteString("{{end}}{{/*\n*/}}")
+		line++
+	}
+	for _, t := range templates {
+		fmt.Fprintf(&sb, "{{template %q .}}", t.Name)
+	}
+	return sb.String(), sm
+}
+
+// TemplateError wraps a text/template execution error with the original source file, line and
+// column it came from, plus a snippet of the offending line, instead of an offset into the
+// aggregated template text.
+type TemplateError struct {
+	SourceFile string
+	Line       int
+	Column     int
+	Snippet    string
+	Err        error
+}
+
+func (e *TemplateError) Error() string {
+	if e.SourceFile == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s:%d:%d: %v", e.SourceFile, e.Line, e.Column, e.Err)
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// execErrorPosition matches the "name:line:col:" prefix text/template.ExecError renders its
+// message with.
+var execErrorPosition = regexp.MustCompile(`:(\d+):(\d+):`)
+
+// wrapExecError, given the error returned by a template.Execute call over templateText, tries to
+// remap its aggregated-text position through sm into a TemplateError. If err isn't a
+// template.ExecError, or its message doesn't carry a line:column, err is returned unchanged.
+func wrapExecError(err error, templateText string, sm SourceMap) error {
+	if err == nil {
+		return nil
+	}
+	var execErr template.ExecError
+	if !errors.As(err, &execErr) {
+		return err
+	}
+	m := execErrorPosition.FindStringSubmatch(execErr.Error())
+	if m == nil {
+		return err
+	}
+	aggregatedLine, _ := strconv.Atoi(m[1])
+	column, _ := strconv.Atoi(m[2])
+	sourceFile, line := sm.locate(aggregatedLine)
+	return &TemplateError{
+		SourceFile: sourceFile,
+		Line:       line,
+		Column:     column,
+		Snippet:    snippetAt(templateText, aggregatedLine),
+		Err:        execErr,
+	}
+}
+
+// snippetAt returns the trimmed content of the given 1-indexed line of text, or "" if out of range.
+func snippetAt(text string, line int) string {
+	lines := strings.Split(text, "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	return strings.TrimSpace(lines[line-1])
+}
+
+// JSONDiagnostics returns a bavard option to be used in Generate, GenerateFS or GenerateBatch.
+// If set to true, a template execution error is also emitted to stderr as a single-line JSON
+// diagnostic (see diagnostic), for editors and CI to parse. This is independent of Verbose,
+// which only controls the "generating <file>" progress line.
+func JSONDiagnostics(v bool) func(*Bavard) error {
+	return func(b *Bavard) error {
+		b.jsonDiagnostics = v
+		return nil
+	}
+}
+
+// diagnostic is the -json stream shape emitted for a TemplateError when JSONDiagnostics(true) is
+// set, deliberately kept close to the fields `go vet -json` reports so editors and CI that
+// already parse one can parse the other.
+type diagnostic struct {
+	SourceFile string `json:"sourceFile"`
+	Line       int    `json:"line"`
+	Column     int    `json:"column"`
+	Message    string `json:"message"`
+}
+
+// emitDiagnostic writes e as a single-line JSON diagnostic to stderr.
+func emitDiagnostic(e *TemplateError) {
+	_ = json.NewEncoder(os.Stderr).Encode(diagnostic{
+		SourceFile: e.SourceFile,
+		Line:       e.Line,
+		Column:     e.Column,
+		Message:    e.Err.Error(),
+	})
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 ConsenSys Software Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bavard
+
+import "testing"
+
+func TestAggregateWithSourceMapLocate(t *testing.T) {
+	templates := []NamedTemplate{
+		{Name: "a.tmpl", Content: "line1\nline2\nline3"},
+		{Name: "b.tmpl", Content: "line1\nline2"},
+	}
+	_, sm := AggregateWithSourceMap(templates)
+
+	cases := []struct {
+		aggregatedLine int
+		wantName       string
+		wantLine       int
+	}{
+		{1, "a.tmpl", 1},
+		{2, "a.tmpl", 2},
+		{3, "a.tmpl", 3},
+		{4, "b.tmpl", 1},
+		{5, "b.tmpl", 2},
+	}
+
+	for _, c := range cases {
+		name, line := sm.locate(c.aggregatedLine)
+		if name != c.wantName || line != c.wantLine {
+			t.Errorf("locate(%d) = (%q, %d), want (%q, %d)", c.aggregatedLine, name, line, c.wantName, c.wantLine)
+		}
+	}
+}
+
+func TestAggregateWithSourceMapLocateBeforeFirstEntry(t *testing.T) {
+	_, sm := AggregateWithSourceMap([]NamedTemplate{{Name: "only.tmpl", Content: "x"}})
+
+	name, line := sm.locate(0)
+	if name != "" || line != 0 {
+		t.Errorf("locate(0) = (%q, %d), want (\"\", 0)", name, line)
+	}
+}
+
+func TestSnippetAt(t *testing.T) {
+	text := "one\ntwo\nthree"
+
+	cases := []struct {
+		line int
+		want string
+	}{
+		{1, "one"},
+		{2, "two"},
+		{3, "three"},
+		{0, ""},
+		{4, ""},
+	}
+
+	for _, c := range cases {
+		if got := snippetAt(text, c.line); got != c.want {
+			t.Errorf("snippetAt(_, %d) = %q, want %q", c.line, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2020 ConsenSys Software Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bavard
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Formatter reformats the file at path in place, after bavard has written the generated code to it
+type Formatter interface {
+	Format(path string) error
+}
+
+// FormatterFunc is an adapter allowing the use of ordinary functions as Formatters
+type FormatterFunc func(path string) error
+
+// Format calls f(path)
+func (f FormatterFunc) Format(path string) error {
+	return f(path)
+}
+
+// formatters is the package-wide registry of Formatter by file extension (including the leading dot)
+var formatters = map[string]Formatter{
+	".go":    FormatterFunc(formatGo),
+	".s":     FormatterFunc(formatAsm),
+	".proto": FormatterFunc(formatProto),
+	".c":     FormatterFunc(formatClang),
+	".h":     FormatterFunc(formatClang),
+	".py":    FormatterFunc(formatPython),
+	".sh":    FormatterFunc(formatShell),
+}
+
+// Register adds or replaces the Formatter used by Generate for files with the given extension
+// (including the leading dot, e.g. ".proto")
+func Register(ext string, f Formatter) {
+	formatters[ext] = f
+}
+
+// WithFormatter returns a bavard option registering f as the Formatter for ext, for this call to Generate only
+func WithFormatter(ext string, f Formatter) func(*Bavard) error {
+	return func(b *Bavard) error {
+		if b.formatters == nil {
+			b.formatters = make(map[string]Formatter)
+		}
+		b.formatters[ext] = f
+		return nil
+	}
+}
+
+// formatGo runs gofmt -s -w on path
+func formatGo(path string) error {
+	cmd := exec.Command("gofmt", "-s", "-w", path)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// formatAsm is a quick and dirty formatter: it collapses repeated blank lines and indents
+// everything after the first "TEXT " directive, then rewrites path with the result
+func formatAsm(path string) error {
+	// 1- create result buffer
+	var result bytes.Buffer
+
+	// 2- open file
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(file)
+	prevLine := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		isJump := line == ""
+		if (isJump && !prevLine) || !isJump {
+			result.WriteString(line)
+			result.WriteByte('\n')
+		}
+		if strings.HasPrefix(line, "TEXT ") {
+			break
+		}
+		prevLine = isJump
+	}
+	prevLine = false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		isJump := line == ""
+		if (isJump && !prevLine) || !isJump {
+			result.WriteString("    " + line)
+			result.WriteByte('\n')
+		}
+		prevLine = isJump
+	}
+
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return err
+	}
+	file.Close()
+
+	return ioutil.WriteFile(path, result.Bytes(), 0644)
+}
+
+// formatProto runs buf format on path, falling back to clang-format if buf is not installed
+func formatProto(path string) error {
+	if _, err := exec.LookPath("buf"); err == nil {
+		cmd := exec.Command("buf", "format", "-w", path)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+	return formatClang(path)
+}
+
+// formatClang runs clang-format -i on path
+func formatClang(path string) error {
+	cmd := exec.Command("clang-format", "-i", path)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// formatPython runs black on path
+func formatPython(path string) error {
+	cmd := exec.Command("black", path)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// formatShell runs shfmt -w on path
+func formatShell(path string) error {
+	cmd := exec.Command("shfmt", "-w", path)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 ConsenSys Software Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bavard
+
+import (
+	"io/fs"
+	"sort"
+)
+
+// NamedTemplate pairs a template's source Content with the Name it is registered under, so it
+// can be referenced from another template (see Include) once aggregated, and so template errors
+// can be traced back to it (see AggregateWithSourceMap).
+type NamedTemplate struct {
+	Name    string
+	Content string
+}
+
+// GenerateFS behaves like Generate, but reads templates from fsys instead of being passed raw
+// strings. patterns are matched against fsys with fs.Glob, each matching file is registered as
+// a named template keyed by its path, and the files are executed in sorted-path order — exactly
+// as Generate concatenates its templates. Because each file keeps its own name, templates can
+// reference one another with {{ template "path/to/file" . }}, which the previous plain
+// string-concatenation approach could not support reliably.
+//
+// They can also call the Include template function, with the signature
+// Include(name string, data interface{}) (string, error): template funcs have no implicit ".",
+// so the data to render the included template with must be passed explicitly, e.g.
+// {{ Include "path/to/file" . }} or {{ Include "path/to/file" .SomeField }}.
+func GenerateFS(fsys fs.FS, output string, patterns []string, data interface{}, options ...func(*Bavard) error) error {
+	var b Bavard
+
+	// default settings, mirroring Generate
+	b.imports = true
+	b.fmt = true
+	b.verbose = true
+	b.generated = "bavard"
+
+	for _, option := range options {
+		if err := option(&b); err != nil {
+			return err
+		}
+	}
+
+	named, err := readNamedTemplates(fsys, patterns)
+	if err != nil {
+		return err
+	}
+
+	text, sm := AggregateWithSourceMap(named)
+	_, err = generate(&b, output, text, sm, data)
+	return err
+}
+
+// readNamedTemplates expands patterns against fsys and returns the matched files, sorted by
+// path, as NamedTemplate.
+func readNamedTemplates(fsys fs.FS, patterns []string) ([]NamedTemplate, error) {
+	var paths []string
+	for _, pattern := range patterns {
+		matches, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, matches...)
+	}
+	sort.Strings(paths)
+
+	named := make([]NamedTemplate, 0, len(paths))
+	for _, path := range paths {
+		content, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return nil, err
+		}
+		named = append(named, NamedTemplate{Name: path, Content: string(content)})
+	}
+	return named, nil
+}